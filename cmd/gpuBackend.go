@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/wminshew/emrys/pkg/check"
+	"github.com/wminshew/gonvml"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	gpuBackendNVML    = "nvml"
+	gpuBackendROCmSMI = "rocmsmi"
+
+	// amdVendorID is the PCI vendor ID AMD GPUs report at
+	// /sys/class/drm/card*/device/vendor.
+	amdVendorID = "0x1002"
+)
+
+// gpuBackend abstracts the GPU vendor library used to enumerate and query
+// devices, so a monitor loop doesn't need to know whether it's talking to
+// NVML (Nvidia) or ROCm SMI (AMD).
+type gpuBackend interface {
+	Init() error
+	Shutdown() error
+	DriverVersion() (string, error)
+	Devices() ([]uint, error)
+	Snapshot(idx uint) (GPUSnapshot, error)
+}
+
+// selectGPUBackendName picks which gpuBackend to use. gpu_backend
+// overrides the choice ("nvml" or "rocmsmi"); otherwise NVML is probed
+// first, falling back to ROCm SMI, so the miner participates on AMD
+// MI-series/Radeon rigs instead of erroring out at startup.
+func selectGPUBackendName() (string, error) {
+	switch backend := viper.GetString("gpu_backend"); backend {
+	case gpuBackendNVML, gpuBackendROCmSMI:
+		return backend, nil
+	case "":
+		if probeNVML() {
+			return gpuBackendNVML, nil
+		}
+		if probeROCmSMI() {
+			return gpuBackendROCmSMI, nil
+		}
+		return "", fmt.Errorf("no supported GPU backend found (checked NVML, ROCm SMI)")
+	default:
+		return "", fmt.Errorf("unknown gpu_backend %q", backend)
+	}
+}
+
+func probeNVML() bool {
+	if err := gonvml.Initialize(); err != nil {
+		return false
+	}
+	defer check.Err(gonvml.Shutdown)
+
+	n, err := gonvml.DeviceCount()
+	return err == nil && n > 0
+}
+
+func probeROCmSMI() bool {
+	matches, err := filepath.Glob("/sys/class/drm/card[0-9]*/device/vendor")
+	if err != nil {
+		return false
+	}
+	for _, m := range matches {
+		b, err := ioutil.ReadFile(m)
+		if err == nil && strings.TrimSpace(string(b)) == amdVendorID {
+			return true
+		}
+	}
+	return false
+}
+
+// monitorGenericBackend runs the vendor-agnostic monitor loop against any
+// gpuBackend. It's used for backends (currently just ROCm SMI) that don't
+// have an NVML-specific loop with MIG/NVLink/job-accounting support.
+func monitorGenericBackend(ctx context.Context, backend gpuBackend) error {
+	if err := backend.Init(); err != nil {
+		return fmt.Errorf("initializing gpu backend: %w", err)
+	}
+	defer check.Err(backend.Shutdown)
+
+	driverVersion, err := backend.DriverVersion()
+	if err != nil {
+		return fmt.Errorf("finding gpu driver version: %w", err)
+	}
+	log.Printf("GPU driver: %v", driverVersion)
+
+	devices, err := backend.Devices()
+	if err != nil {
+		return fmt.Errorf("enumerating gpu devices: %w", err)
+	}
+
+	sink := newSink(ctx)
+
+	for {
+		for _, i := range devices {
+			g, err := backend.Snapshot(i)
+			if err != nil {
+				log.Printf("Snapshot(%d) error: %v", i, err)
+				continue
+			}
+			if err := sink.Emit(ctx, g); err != nil {
+				log.Printf("sink.Emit() error: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(gpuPeriod):
+		}
+	}
+}
+
+// nvmlBackend is the gpuBackend implementation backed by NVML, wrapping
+// the same gonvml calls monitorNVML uses directly for its device setup
+// and MIG/topology/thermal handling. monitorNVML reuses it for the
+// common Init/DriverVersion/Devices steps rather than duplicating them;
+// it snapshots devices through snapshotDevice directly, not Snapshot.
+type nvmlBackend struct{}
+
+func (b *nvmlBackend) Init() error {
+	if err := gonvml.Initialize(); err != nil {
+		return fmt.Errorf("initializing gonvml: %w. Make sure NVML is in the shared library search path", err)
+	}
+	return nil
+}
+
+func (b *nvmlBackend) Shutdown() error {
+	return gonvml.Shutdown()
+}
+
+func (b *nvmlBackend) DriverVersion() (string, error) {
+	return gonvml.SystemDriverVersion()
+}
+
+func (b *nvmlBackend) Devices() ([]uint, error) {
+	devices := []uint{}
+	devicesStr := viper.GetStringSlice("devices")
+	if len(devicesStr) == 0 {
+		// no flag provided, grab all detected devices
+		numDevices, err := gonvml.DeviceCount()
+		if err != nil {
+			return nil, fmt.Errorf("counting nvidia devices: %w", err)
+		}
+		for i := 0; i < int(numDevices); i++ {
+			devices = append(devices, uint(i))
+		}
+		return devices, nil
+	}
+
+	// flag provided, convert to uints
+	for _, s := range devicesStr {
+		u, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid devices entry %s: %w", s, err)
+		}
+		devices = append(devices, uint(u))
+	}
+	return devices, nil
+}
+
+func (b *nvmlBackend) Snapshot(idx uint) (GPUSnapshot, error) {
+	dev, err := gonvml.DeviceHandleByIndex(idx)
+	if err != nil {
+		return GPUSnapshot{}, fmt.Errorf("DeviceHandleByIndex(%d): %w", idx, err)
+	}
+	g, ok := snapshotDevice(dev)
+	if !ok {
+		return GPUSnapshot{}, fmt.Errorf("snapshotDevice(%d): failed to read device", idx)
+	}
+	return g, nil
+}
+
+// rocmsmiBackend reads AMD GPU telemetry directly from the sysfs nodes
+// ROCm SMI itself reads from (/sys/class/drm/card*/device/), avoiding a
+// cgo dependency on the ROCm SMI shared library.
+type rocmsmiBackend struct {
+	cardDirs []string
+}
+
+func (b *rocmsmiBackend) Init() error {
+	matches, err := filepath.Glob("/sys/class/drm/card[0-9]*/device/vendor")
+	if err != nil {
+		return fmt.Errorf("globbing /sys/class/drm: %w", err)
+	}
+	for _, m := range matches {
+		vendor, err := ioutil.ReadFile(m)
+		if err != nil || strings.TrimSpace(string(vendor)) != amdVendorID {
+			continue
+		}
+		b.cardDirs = append(b.cardDirs, filepath.Dir(m))
+	}
+	if len(b.cardDirs) == 0 {
+		return fmt.Errorf("no AMD GPUs found under /sys/class/drm")
+	}
+	return nil
+}
+
+func (b *rocmsmiBackend) Shutdown() error {
+	return nil
+}
+
+func (b *rocmsmiBackend) DriverVersion() (string, error) {
+	b_, err := ioutil.ReadFile("/sys/module/amdgpu/version")
+	if err != nil {
+		return "", fmt.Errorf("reading amdgpu driver version: %w", err)
+	}
+	return strings.TrimSpace(string(b_)), nil
+}
+
+func (b *rocmsmiBackend) Devices() ([]uint, error) {
+	devices := make([]uint, len(b.cardDirs))
+	for i := range b.cardDirs {
+		devices[i] = uint(i)
+	}
+	return devices, nil
+}
+
+func (b *rocmsmiBackend) Snapshot(idx uint) (GPUSnapshot, error) {
+	if int(idx) >= len(b.cardDirs) {
+		return GPUSnapshot{}, fmt.Errorf("device index %d out of range", idx)
+	}
+	dir := b.cardDirs[idx]
+
+	g := GPUSnapshot{
+		TimeStamp:   time.Now().Unix(),
+		MinorNumber: idx,
+	}
+
+	if uuid, err := readSysfsString(filepath.Join(dir, "unique_id")); err == nil {
+		g.UUID = uuid
+	}
+
+	if util, err := readSysfsUint(filepath.Join(dir, "gpu_busy_percent")); err == nil {
+		g.AvgGPUUtilization = util
+	}
+
+	if totalMem, err := readSysfsUint(filepath.Join(dir, "mem_info_vram_total")); err == nil {
+		g.TotalMemory = uint64(totalMem)
+	}
+	if usedMem, err := readSysfsUint(filepath.Join(dir, "mem_info_vram_used")); err == nil {
+		g.UsedMemory = uint64(usedMem)
+	}
+
+	hwmonDir, err := findHwmonDir(dir)
+	if err == nil {
+		if power, err := readSysfsUint(filepath.Join(hwmonDir, "power1_average")); err == nil {
+			g.AvgPowerUsage = power / 1000000 // microwatts -> watts
+		}
+		if temp, err := readSysfsUint(filepath.Join(hwmonDir, "temp1_input")); err == nil {
+			g.Temperature = temp / 1000 // millidegrees C -> degrees C
+		}
+		if fan, err := readSysfsUint(filepath.Join(hwmonDir, "fan1_input")); err == nil {
+			g.FanSpeed = fan
+		}
+	}
+
+	return g, nil
+}
+
+func findHwmonDir(cardDir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(cardDir, "hwmon", "hwmon*"))
+	if err != nil || len(matches) == 0 {
+		return "", fmt.Errorf("no hwmon directory under %s", cardDir)
+	}
+	return matches[0], nil
+}
+
+func readSysfsString(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func readSysfsUint(path string) (uint, error) {
+	s, err := readSysfsString(path)
+	if err != nil {
+		return 0, err
+	}
+	u, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint(u), nil
+}
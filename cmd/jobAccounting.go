@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"github.com/wminshew/gonvml"
+	"log"
+	"syscall"
+	"time"
+)
+
+const jobAccountingPeriod = 10 * time.Second
+
+// JobGPUUsage attributes a device's per-process GPU resource consumption
+// to a single PID. Unlike GPUSnapshot, which reports the device as a
+// whole, this lets the miner account for (and bill) exactly what one
+// process used.
+type JobGPUUsage struct {
+	TimeStamp     int64
+	MinorNumber   uint
+	PID           uint
+	UsedGpuMemory uint64
+	SmUtil        uint
+	MemUtil       uint
+	EnergyJoules  uint64
+	TimeRunning   time.Duration
+}
+
+// monitorJobGPU polls per-process accounting stats for the device at
+// minorIdx, which monitorNVML has already put into EXCLUSIVE_PROCESS
+// compute mode with accounting enabled. Exclusive mode means at most one
+// process is meant to hold the device at a time: monitorJobGPU treats
+// the first PID it observes as that device's owner and attributes usage
+// to it via sink.EmitJobUsage. Any other PID that shows up afterward
+// isn't supposed to be there, so it's logged and, if
+// job_accounting.kill_foreign_processes is set, killed, since it's
+// silently stealing capacity the miner is being paid to dedicate to
+// whatever owns the device.
+func monitorJobGPU(ctx context.Context, minorIdx uint, sink JobUsageSink) {
+	killForeign := viper.GetBool("job_accounting.kill_foreign_processes")
+
+	ticker := time.NewTicker(jobAccountingPeriod)
+	defer ticker.Stop()
+
+	var ownerPID uint
+	haveOwner := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		dev, err := gonvml.DeviceHandleByIndex(minorIdx)
+		if err != nil {
+			log.Printf("DeviceHandleByIndex(%d) error: %v", minorIdx, err)
+			continue
+		}
+
+		procs, err := dev.ComputeRunningProcesses()
+		if err != nil {
+			log.Printf("ComputeRunningProcesses() error: %v", err)
+			continue
+		}
+
+		for _, proc := range procs {
+			if !haveOwner {
+				ownerPID = proc.PID
+				haveOwner = true
+			}
+
+			if proc.PID != ownerPID {
+				log.Printf("foreign PID %d found on device %d (owner is %d)", proc.PID, minorIdx, ownerPID)
+				if killForeign {
+					if err := syscall.Kill(int(proc.PID), syscall.SIGKILL); err != nil {
+						log.Printf("Kill(%d) error: %v", proc.PID, err)
+					}
+				}
+				continue
+			}
+
+			stats, err := dev.AccountingStats(proc.PID)
+			if err != nil {
+				log.Printf("AccountingStats(%d) error: %v", proc.PID, err)
+				continue
+			}
+
+			u := JobGPUUsage{
+				TimeStamp:     time.Now().Unix(),
+				MinorNumber:   minorIdx,
+				PID:           proc.PID,
+				UsedGpuMemory: proc.UsedGpuMemory,
+				SmUtil:        stats.SmUtil,
+				MemUtil:       stats.MemUtil,
+				EnergyJoules:  stats.EnergyJoules,
+				TimeRunning:   time.Duration(stats.Time) * time.Millisecond,
+			}
+			if err := sink.EmitJobUsage(ctx, u); err != nil {
+				log.Printf("sink.EmitJobUsage() error: %v", err)
+			}
+		}
+
+		if len(procs) == 0 {
+			// the owning process exited; the next process to appear on
+			// this (still exclusive) device is a new job, not the old one.
+			haveOwner = false
+		}
+	}
+}
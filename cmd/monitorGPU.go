@@ -2,12 +2,16 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
 	"github.com/spf13/viper"
 	"github.com/wminshew/emrys/pkg/check"
 	// "github.com/wminshew/emrys/pkg/job"
 	"github.com/wminshew/gonvml"
 	"log"
-	"strconv"
+	"sync"
 	"time"
 )
 
@@ -39,241 +43,555 @@ type GPUSnapshot struct {
 	PcieMaxWidth      uint
 	Temperature       uint
 	FanSpeed          uint
+
+	// IsMIGDevice, GPUInstanceID and ComputeInstanceID are only populated
+	// when this snapshot represents a single MIG (Multi-Instance GPU) slice
+	// rather than the parent device as a whole.
+	IsMIGDevice       bool
+	GPUInstanceID     uint
+	ComputeInstanceID uint
+}
+
+// P2PLinkType classifies how two GPUs on the host can reach each other,
+// from slowest (crossing CPU sockets) to fastest (a direct NVLink).
+type P2PLinkType int
+
+const (
+	P2PLinkUnknown P2PLinkType = iota
+	P2PLinkCrossCPU
+	P2PLinkHostBridge
+	P2PLinkMultiplePCIeSwitches
+	P2PLinkSinglePCIeSwitch
+	P2PLinkSameBoardNVLink
+)
+
+// NVLinkUtilization holds the lane count and cumulative byte counters for
+// a single NVLink connection between two devices.
+type NVLinkUtilization struct {
+	LaneCount uint
+	TxBytes   uint64
+	RxBytes   uint64
+}
+
+// GPUTopology is the adjacency matrix of peer-to-peer link types and
+// NVLink utilization across every monitored device, indexed by position
+// in Devices (which holds NVML device indices, not minor numbers).
+type GPUTopology struct {
+	TimeStamp  int64
+	Devices    []uint
+	Links      [][]P2PLinkType
+	NVLinkUtil [][]NVLinkUtilization
+}
+
+// Hash summarizes the link classification (not the throughput counters,
+// which change every tick) so callers can cheaply detect when the
+// topology itself has changed, e.g. after a GPU is added or removed.
+func (t *GPUTopology) Hash() string {
+	h := sha256.New()
+	for _, row := range t.Links {
+		for _, link := range row {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], uint64(link))
+			h.Write(b[:])
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 const (
 	gpuPeriod                   = 10 * time.Second
 	nvmlFeatureEnabled          = 1
 	nvmlComputeExclusiveProcess = 3
+	nvmlMigModeEnabled          = 1
 )
 
-func monitorGPU(ctx context.Context) {
-	if err := gonvml.Initialize(); err != nil {
-		log.Printf("Couldn't initialize gonvml: %v. Make sure NVML is in the shared library search path.", err)
-		panic(err)
+var (
+	currentTopologyMu sync.RWMutex
+	currentTopology   *GPUTopology
+)
+
+func setCurrentTopology(t *GPUTopology) {
+	currentTopologyMu.Lock()
+	defer currentTopologyMu.Unlock()
+	currentTopology = t
+}
+
+// CurrentTopologyHash returns the hash of the most recently discovered
+// GPU topology, or "" before discovery has run. This is the integration
+// point for advertising NVLink affinity to the scheduler: this tree has
+// no scheduler client to call it from yet (pkg/job is still a
+// commented-out import above), so nothing calls it today, but a future
+// advertisement path should read from here rather than parsing logs.
+func CurrentTopologyHash() string {
+	currentTopologyMu.RLock()
+	defer currentTopologyMu.RUnlock()
+	if currentTopology == nil {
+		return ""
+	}
+	return currentTopology.Hash()
+}
+
+// monitorGPU selects a gpuBackend (Nvidia NVML, falling back to AMD ROCm
+// SMI unless gpu_backend overrides the choice) and monitors every
+// configured device on it. It returns an error instead of panicking so a
+// host that's missing the NVML shared library, or has no supported GPU
+// at all, doesn't take down the rest of the miner.
+func monitorGPU(ctx context.Context) error {
+	name, err := selectGPUBackendName()
+	if err != nil {
+		return fmt.Errorf("selecting gpu backend: %w", err)
+	}
+	log.Printf("Using %s gpu backend", name)
+
+	switch name {
+	case gpuBackendNVML:
+		return monitorNVML(ctx)
+	case gpuBackendROCmSMI:
+		return monitorGenericBackend(ctx, &rocmsmiBackend{})
+	default:
+		return fmt.Errorf("unknown gpu_backend %q", name)
+	}
+}
+
+// monitorNVML is the Nvidia-specific monitor loop: it owns MIG
+// enumeration, NVLink topology discovery and thermal throttling, none of
+// which ROCm SMI exposes an equivalent for. It uses nvmlBackend for the
+// common Init/DriverVersion/Devices steps it shares with the generic
+// gpuBackend path.
+func monitorNVML(ctx context.Context) error {
+	backend := &nvmlBackend{}
+	if err := backend.Init(); err != nil {
+		return err
 	}
 	defer check.Err(gonvml.Shutdown)
 
-	driverVersion, err := gonvml.SystemDriverVersion()
+	driverVersion, err := backend.DriverVersion()
 	if err != nil {
-		log.Printf("Error finding nvidia driver: %v", err)
-		return
+		return fmt.Errorf("finding nvidia driver: %w", err)
 	}
 	log.Printf("Nvidia driver: %v", driverVersion)
 
-	devices := []uint{}
-	devicesStr := viper.GetStringSlice("devices")
-	if len(devicesStr) == 0 {
-		// no flag provided, grab all detected devices
-		numDevices, err := gonvml.DeviceCount()
-		if err != nil {
-			log.Printf("Error counting nvidia devices: %v", err)
-			panic(err)
-		}
-		for i := 0; i < int(numDevices); i++ {
-			devices = append(devices, uint(i))
-		}
-	} else {
-		// flag provided, convert to uints
-		for _, s := range devicesStr {
-			u, err := strconv.ParseUint(s, 10, 64)
-			if err != nil {
-				log.Printf("Invalid devices entry %s: %v", s, err)
-				panic(err)
-			}
-			devices = append(devices, uint(u))
-		}
+	devices, err := backend.Devices()
+	if err != nil {
+		return err
 	}
 
 	// initialize
+	exclusiveDevices := map[uint]bool{}
 	for _, i := range devices {
 		dev, err := gonvml.DeviceHandleByIndex(uint(i))
 		if err != nil {
-			log.Printf("DeviceHandleByIndex(%d) error: %v", i, err)
-			panic(err)
+			return fmt.Errorf("DeviceHandleByIndex(%d): %w", i, err)
 		}
 
 		if err := dev.SetPersistenceMode(nvmlFeatureEnabled); err != nil {
-			log.Printf("SetPersistenceMode() error: %v", err)
-			panic(err)
+			return fmt.Errorf("SetPersistenceMode(): %w", err)
+		}
+
+		migMode, _, err := dev.MigMode()
+		if err != nil {
+			log.Printf("MigMode() error: %v", err)
+		}
+		if migMode == nvmlMigModeEnabled {
+			// Compute mode is governed per GPU instance once MIG is on;
+			// NVML rejects setting it on the parent device, so don't try.
+			log.Printf("device %d has MIG enabled, skipping whole-device exclusive compute mode", i)
+			continue
 		}
 
 		if err := dev.SetComputeMode(nvmlComputeExclusiveProcess); err != nil {
-			log.Printf("SetComputeMode() error: %v", err)
-			panic(err)
+			return fmt.Errorf("SetComputeMode(): %w", err)
+		}
+		if err := dev.SetAccountingMode(nvmlFeatureEnabled); err != nil {
+			log.Printf("SetAccountingMode() error: %v", err)
+		}
+		exclusiveDevices[i] = true
+	}
+
+	mig := viper.GetBool("mig")
+	migUUIDAsID := viper.GetBool("mig_uuid_as_id")
+
+	topology := discoverTopology(devices)
+	setCurrentTopology(topology)
+	log.Printf("GPU Topology: %+v (hash=%s)", topology, topology.Hash())
+
+	sink := newSink(ctx)
+	thermal := newThermalController()
+
+	for _, i := range devices {
+		if exclusiveDevices[i] {
+			go monitorJobGPU(ctx, i, sink)
 		}
 	}
 
 	// monitor
 	for {
 		for _, i := range devices {
-			g := GPUSnapshot{}
-			g.TimeStamp = time.Now().Unix()
-
 			dev, err := gonvml.DeviceHandleByIndex(uint(i))
 			if err != nil {
 				log.Printf("DeviceHandleByIndex(%d) error: %v", i, err)
 				continue
 			}
 
-			minorNumber, err := dev.MinorNumber()
-			if err != nil {
-				log.Printf("MinorNumber() error: %v", err)
+			g, ok := snapshotDevice(dev)
+			if !ok {
 				continue
 			}
-			g.MinorNumber = minorNumber
 
-			uuid, err := dev.UUID()
-			if err != nil {
-				log.Printf("UUID() error: %v", err)
+			// Thermal protection applies to the physical device regardless
+			// of whether a MIG slice snapshot is also emitted below, so it
+			// must run before the MIG early-continue.
+			thermal.check(ctx, dev, g, sink)
+
+			emittedMigSnapshot := false
+			if mig {
+				emittedMigSnapshot = monitorMigDevice(ctx, dev, sink)
+			}
+			if emittedMigSnapshot && !migUUIDAsID {
 				continue
 			}
-			g.UUID = uuid
 
-			name, err := dev.Name()
-			if err != nil {
-				log.Printf("Name() error: %v", err)
-				continue
+			if err := sink.Emit(ctx, g); err != nil {
+				log.Printf("sink.Emit() error: %v", err)
 			}
-			g.Name = name
+		}
 
-			brand, err := dev.Brand()
-			if err != nil {
-				log.Printf("Brand() error: %v", err)
+		refreshNVLinkThroughput(topology)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(gpuPeriod):
+		}
+	}
+}
+
+// discoverTopology walks every ordered pair of monitored devices and
+// classifies their peer-to-peer link. It's run once at startup; only the
+// NVLink throughput counters are refreshed afterwards, via
+// refreshNVLinkThroughput, since the physical topology doesn't change at
+// runtime.
+func discoverTopology(devices []uint) *GPUTopology {
+	n := len(devices)
+	t := &GPUTopology{
+		TimeStamp:  time.Now().Unix(),
+		Devices:    append([]uint{}, devices...),
+		Links:      make([][]P2PLinkType, n),
+		NVLinkUtil: make([][]NVLinkUtilization, n),
+	}
+	for i := range t.Links {
+		t.Links[i] = make([]P2PLinkType, n)
+		t.NVLinkUtil[i] = make([]NVLinkUtilization, n)
+	}
+
+	for i, di := range devices {
+		devI, err := gonvml.DeviceHandleByIndex(di)
+		if err != nil {
+			log.Printf("DeviceHandleByIndex(%d) error: %v", di, err)
+			continue
+		}
+
+		for j, dj := range devices {
+			if i == j {
 				continue
 			}
-			g.Brand = brand
-
-			persistenceMode, err := dev.PersistenceMode()
+			devJ, err := gonvml.DeviceHandleByIndex(dj)
 			if err != nil {
-				log.Printf("PersistenceMode() error: %v", err)
+				log.Printf("DeviceHandleByIndex(%d) error: %v", dj, err)
 				continue
 			}
-			g.PersistenceMode = persistenceMode
+			t.Links[i][j] = classifyP2PLink(devI, devJ)
+		}
+	}
 
-			computeMode, err := dev.ComputeMode()
-			if err != nil {
-				log.Printf("ComputeMode() error: %v", err)
+	return t
+}
+
+// classifyP2PLink determines how devI reaches devJ, preferring the
+// explicit NVLink state/capability query and falling back to the common
+// topology ancestor when devI and devJ aren't directly NVLink-connected.
+func classifyP2PLink(devI, devJ gonvml.Device) P2PLinkType {
+	if state, err := devI.NvLinkState(devJ); err == nil && state == nvmlFeatureEnabled {
+		if _, err := devI.NvLinkCapability(devJ); err == nil {
+			return P2PLinkSameBoardNVLink
+		}
+	}
+
+	ancestor, err := devI.TopologyCommonAncestor(devJ)
+	if err != nil {
+		log.Printf("TopologyCommonAncestor() error: %v", err)
+		return P2PLinkUnknown
+	}
+
+	switch ancestor {
+	case gonvml.TopologyNVLink:
+		return P2PLinkSameBoardNVLink
+	case gonvml.TopologySingle:
+		return P2PLinkSinglePCIeSwitch
+	case gonvml.TopologyMultiple:
+		return P2PLinkMultiplePCIeSwitches
+	case gonvml.TopologyHostBridge:
+		return P2PLinkHostBridge
+	case gonvml.TopologyCPU:
+		return P2PLinkCrossCPU
+	default:
+		return P2PLinkCrossCPU
+	}
+}
+
+// refreshNVLinkThroughput updates the lane count and byte counters for
+// every link already classified as NVLink-connected. It leaves the link
+// classification itself untouched.
+func refreshNVLinkThroughput(t *GPUTopology) {
+	for i, di := range t.Devices {
+		devI, err := gonvml.DeviceHandleByIndex(di)
+		if err != nil {
+			log.Printf("DeviceHandleByIndex(%d) error: %v", di, err)
+			continue
+		}
+
+		for j, dj := range t.Devices {
+			if i == j || t.Links[i][j] != P2PLinkSameBoardNVLink {
 				continue
 			}
-			g.ComputeMode = computeMode
-
-			performanceState, err := dev.PerformanceState()
+			devJ, err := gonvml.DeviceHandleByIndex(dj)
 			if err != nil {
-				log.Printf("PerformanceState() error: %v", err)
+				log.Printf("DeviceHandleByIndex(%d) error: %v", dj, err)
 				continue
 			}
-			g.PerformanceState = performanceState
 
-			gpuUtilization, err := dev.AverageGPUUtilization(gpuPeriod)
+			laneCount, err := devI.NvLinkCapability(devJ)
 			if err != nil {
-				log.Printf("UtilizationRates() error: %v", err)
+				log.Printf("NvLinkCapability() error: %v", err)
 			}
-			g.AvgGPUUtilization = gpuUtilization
-
-			powerUsage, err := dev.AveragePowerUsage(gpuPeriod)
+			txBytes, rxBytes, err := devI.NvLinkThroughput(devJ)
 			if err != nil {
-				log.Printf("PowerUsage() error: %v", err)
+				log.Printf("NvLinkThroughput() error: %v", err)
 			}
-			g.AvgPowerUsage = powerUsage
-
-			totalMemory, usedMemory, err := dev.MemoryInfo()
-			if err != nil {
-				log.Printf("MemoryInfo() error: %v", err)
+			t.NVLinkUtil[i][j] = NVLinkUtilization{
+				LaneCount: laneCount,
+				TxBytes:   txBytes,
+				RxBytes:   rxBytes,
 			}
-			g.TotalMemory = totalMemory
-			g.UsedMemory = usedMemory
+		}
+	}
+	t.TimeStamp = time.Now().Unix()
+}
 
-			grClock, err := dev.GrClock()
-			if err != nil {
-				log.Printf("GrClock() error: %v", err)
-			}
-			g.GrClock = grClock
+// monitorMigDevice checks whether dev has MIG mode enabled, and if so,
+// enumerates and snapshots each of its GPU instances individually. It
+// returns true if at least one MIG-slice snapshot was emitted.
+func monitorMigDevice(ctx context.Context, dev gonvml.Device, sink SnapshotSink) bool {
+	migMode, _, err := dev.MigMode()
+	if err != nil {
+		log.Printf("MigMode() error: %v", err)
+		return false
+	}
+	if migMode != nvmlMigModeEnabled {
+		return false
+	}
 
-			smClock, err := dev.SMClock()
-			if err != nil {
-				log.Printf("SMClock() error: %v", err)
-			}
-			g.SMClock = smClock
+	maxMigDevices, err := dev.MaxMigDeviceCount()
+	if err != nil {
+		log.Printf("MaxMigDeviceCount() error: %v", err)
+		return false
+	}
 
-			memClock, err := dev.MemClock()
-			if err != nil {
-				log.Printf("MemClock() error: %v", err)
-			}
-			g.MemClock = memClock
+	emitted := false
+	for mi := uint(0); mi < maxMigDevices; mi++ {
+		migDev, err := dev.MigDeviceHandleByIndex(mi)
+		if err != nil {
+			// indices are not necessarily contiguous; a given slot may
+			// simply be unpopulated
+			continue
+		}
 
-			grMaxClock, err := dev.GrMaxClock()
-			if err != nil {
-				log.Printf("GrMaxClock() error: %v", err)
-			}
-			g.GrMaxClock = grMaxClock
+		g, ok := snapshotDevice(migDev)
+		if !ok {
+			continue
+		}
+		g.IsMIGDevice = true
 
-			smMaxClock, err := dev.SMMaxClock()
-			if err != nil {
-				log.Printf("SMMaxClock() error: %v", err)
-			}
-			g.SMMaxClock = smMaxClock
+		gpuInstanceID, err := migDev.GPUInstanceID()
+		if err != nil {
+			log.Printf("GPUInstanceID() error: %v", err)
+		}
+		g.GPUInstanceID = gpuInstanceID
 
-			memMaxClock, err := dev.MemMaxClock()
-			if err != nil {
-				log.Printf("MemMaxClock() error: %v", err)
-			}
-			g.MemMaxClock = memMaxClock
+		computeInstanceID, err := migDev.ComputeInstanceID()
+		if err != nil {
+			log.Printf("ComputeInstanceID() error: %v", err)
+		}
+		g.ComputeInstanceID = computeInstanceID
 
-			pcieTxThroughput, err := dev.PcieTxThroughput()
-			if err != nil {
-				log.Printf("PcieTxThroughput() error: %v", err)
-			}
-			g.PcieTxThroughput = pcieTxThroughput
+		if err := sink.Emit(ctx, g); err != nil {
+			log.Printf("sink.Emit() error: %v", err)
+		}
+		emitted = true
+	}
 
-			pcieRxThroughput, err := dev.PcieRxThroughput()
-			if err != nil {
-				log.Printf("PcieRxThroughput() error: %v", err)
-			}
-			g.PcieRxThroughput = pcieRxThroughput
+	return emitted
+}
 
-			pcieGen, err := dev.PcieGeneration()
-			if err != nil {
-				log.Printf("PcieGeneration() error: %v", err)
-			}
-			g.PcieGeneration = pcieGen
+// snapshotDevice collects a GPUSnapshot from dev, which may be either a
+// full physical device or a single MIG device handle. It returns false if
+// an identifying property of the device couldn't be read, in which case
+// the caller should skip this device for the current tick.
+func snapshotDevice(dev gonvml.Device) (GPUSnapshot, bool) {
+	g := GPUSnapshot{}
+	g.TimeStamp = time.Now().Unix()
 
-			pcieWidth, err := dev.PcieWidth()
-			if err != nil {
-				log.Printf("PcieGeneration() error: %v", err)
-			}
-			g.PcieWidth = pcieWidth
+	minorNumber, err := dev.MinorNumber()
+	if err != nil {
+		log.Printf("MinorNumber() error: %v", err)
+		return g, false
+	}
+	g.MinorNumber = minorNumber
 
-			pcieMaxGeneration, err := dev.PcieMaxGeneration()
-			if err != nil {
-				log.Printf("PcieGeneration() error: %v", err)
-			}
-			g.PcieMaxGeneration = pcieMaxGeneration
+	uuid, err := dev.UUID()
+	if err != nil {
+		log.Printf("UUID() error: %v", err)
+		return g, false
+	}
+	g.UUID = uuid
 
-			pcieMaxWidth, err := dev.PcieMaxWidth()
-			if err != nil {
-				log.Printf("PcieGeneration() error: %v", err)
-			}
-			g.PcieMaxWidth = pcieMaxWidth
+	name, err := dev.Name()
+	if err != nil {
+		log.Printf("Name() error: %v", err)
+		return g, false
+	}
+	g.Name = name
 
-			temperature, err := dev.Temperature()
-			if err != nil {
-				log.Printf("Temperature() error: %v", err)
-			}
-			g.Temperature = temperature
+	brand, err := dev.Brand()
+	if err != nil {
+		log.Printf("Brand() error: %v", err)
+		return g, false
+	}
+	g.Brand = brand
 
-			fanSpeed, err := dev.FanSpeed()
-			if err != nil {
-				log.Printf("FanSpeed() error: %v", err)
-			}
-			g.FanSpeed = fanSpeed
+	persistenceMode, err := dev.PersistenceMode()
+	if err != nil {
+		log.Printf("PersistenceMode() error: %v", err)
+		return g, false
+	}
+	g.PersistenceMode = persistenceMode
 
-			log.Printf("GPU Snapshot: %+v", g)
-		}
-		select {
-		case <-ctx.Done():
-			return
-		case <-time.After(gpuPeriod):
-		}
+	computeMode, err := dev.ComputeMode()
+	if err != nil {
+		log.Printf("ComputeMode() error: %v", err)
+		return g, false
+	}
+	g.ComputeMode = computeMode
+
+	performanceState, err := dev.PerformanceState()
+	if err != nil {
+		log.Printf("PerformanceState() error: %v", err)
+		return g, false
 	}
+	g.PerformanceState = performanceState
+
+	gpuUtilization, err := dev.AverageGPUUtilization(gpuPeriod)
+	if err != nil {
+		log.Printf("UtilizationRates() error: %v", err)
+	}
+	g.AvgGPUUtilization = gpuUtilization
+
+	powerUsage, err := dev.AveragePowerUsage(gpuPeriod)
+	if err != nil {
+		log.Printf("PowerUsage() error: %v", err)
+	}
+	g.AvgPowerUsage = powerUsage
+
+	totalMemory, usedMemory, err := dev.MemoryInfo()
+	if err != nil {
+		log.Printf("MemoryInfo() error: %v", err)
+	}
+	g.TotalMemory = totalMemory
+	g.UsedMemory = usedMemory
+
+	grClock, err := dev.GrClock()
+	if err != nil {
+		log.Printf("GrClock() error: %v", err)
+	}
+	g.GrClock = grClock
+
+	smClock, err := dev.SMClock()
+	if err != nil {
+		log.Printf("SMClock() error: %v", err)
+	}
+	g.SMClock = smClock
+
+	memClock, err := dev.MemClock()
+	if err != nil {
+		log.Printf("MemClock() error: %v", err)
+	}
+	g.MemClock = memClock
+
+	grMaxClock, err := dev.GrMaxClock()
+	if err != nil {
+		log.Printf("GrMaxClock() error: %v", err)
+	}
+	g.GrMaxClock = grMaxClock
+
+	smMaxClock, err := dev.SMMaxClock()
+	if err != nil {
+		log.Printf("SMMaxClock() error: %v", err)
+	}
+	g.SMMaxClock = smMaxClock
+
+	memMaxClock, err := dev.MemMaxClock()
+	if err != nil {
+		log.Printf("MemMaxClock() error: %v", err)
+	}
+	g.MemMaxClock = memMaxClock
+
+	pcieTxThroughput, err := dev.PcieTxThroughput()
+	if err != nil {
+		log.Printf("PcieTxThroughput() error: %v", err)
+	}
+	g.PcieTxThroughput = pcieTxThroughput
+
+	pcieRxThroughput, err := dev.PcieRxThroughput()
+	if err != nil {
+		log.Printf("PcieRxThroughput() error: %v", err)
+	}
+	g.PcieRxThroughput = pcieRxThroughput
+
+	pcieGen, err := dev.PcieGeneration()
+	if err != nil {
+		log.Printf("PcieGeneration() error: %v", err)
+	}
+	g.PcieGeneration = pcieGen
+
+	pcieWidth, err := dev.PcieWidth()
+	if err != nil {
+		log.Printf("PcieWidth() error: %v", err)
+	}
+	g.PcieWidth = pcieWidth
+
+	pcieMaxGeneration, err := dev.PcieMaxGeneration()
+	if err != nil {
+		log.Printf("PcieMaxGeneration() error: %v", err)
+	}
+	g.PcieMaxGeneration = pcieMaxGeneration
+
+	pcieMaxWidth, err := dev.PcieMaxWidth()
+	if err != nil {
+		log.Printf("PcieMaxWidth() error: %v", err)
+	}
+	g.PcieMaxWidth = pcieMaxWidth
+
+	temperature, err := dev.Temperature()
+	if err != nil {
+		log.Printf("Temperature() error: %v", err)
+	}
+	g.Temperature = temperature
+
+	fanSpeed, err := dev.FanSpeed()
+	if err != nil {
+		log.Printf("FanSpeed() error: %v", err)
+	}
+	g.FanSpeed = fanSpeed
+
+	return g, true
 }
@@ -0,0 +1,327 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/wminshew/emrys/pkg/check"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SnapshotSink is the destination GPUSnapshots are emitted to. Selecting
+// a sink is a pure configuration concern (see newSink) so monitorGPU
+// doesn't need to know whether it's writing to stdout, a file, or a
+// remote time-series database.
+type SnapshotSink interface {
+	Emit(ctx context.Context, g GPUSnapshot) error
+}
+
+// ThermalSink is the destination ThermalEvents (state transitions from
+// the thermal self-throttling control loop) are emitted to.
+type ThermalSink interface {
+	EmitThermal(ctx context.Context, e ThermalEvent) error
+}
+
+// JobUsageSink is the destination JobGPUUsage records (per-process GPU
+// accounting) are emitted to.
+type JobUsageSink interface {
+	EmitJobUsage(ctx context.Context, u JobGPUUsage) error
+}
+
+// Sink is the combined destination for GPUSnapshots, ThermalEvents and
+// JobGPUUsage records; every concrete sink below implements all three.
+type Sink interface {
+	SnapshotSink
+	ThermalSink
+	JobUsageSink
+}
+
+// newSink builds the Sink configured via viper's "sink.*" keys,
+// defaulting to stdout logging when none is set. ctx governs the
+// lifetime of any background goroutines the sink starts (e.g.
+// influxSink's flushLoop), so they stop on shutdown instead of leaking.
+func newSink(ctx context.Context) Sink {
+	switch strings.ToLower(viper.GetString("sink.type")) {
+	case "file":
+		return newFileSink(viper.GetString("sink.path"))
+	case "influxdb", "influx":
+		return newInfluxSink(ctx)
+	default:
+		return &stdoutSink{}
+	}
+}
+
+// stdoutSink preserves the original behavior: log.Printf("GPU Snapshot: %+v", g).
+type stdoutSink struct{}
+
+func (s *stdoutSink) Emit(ctx context.Context, g GPUSnapshot) error {
+	log.Printf("GPU Snapshot: %+v", g)
+	return nil
+}
+
+func (s *stdoutSink) EmitThermal(ctx context.Context, e ThermalEvent) error {
+	log.Printf("Thermal Event: %+v", e)
+	return nil
+}
+
+func (s *stdoutSink) EmitJobUsage(ctx context.Context, u JobGPUUsage) error {
+	log.Printf("Job GPU Usage: %+v", u)
+	return nil
+}
+
+// fileSink appends each snapshot as a JSON line, rotating to a new file
+// every day so a single file doesn't grow unbounded.
+type fileSink struct {
+	mu       sync.Mutex
+	basePath string
+	day      string
+	f        *os.File
+}
+
+func newFileSink(basePath string) *fileSink {
+	if basePath == "" {
+		basePath = "gpu_snapshots.json"
+	}
+	return &fileSink{basePath: basePath}
+}
+
+func (s *fileSink) Emit(ctx context.Context, g GPUSnapshot) error {
+	return s.writeJSONLine(g)
+}
+
+func (s *fileSink) EmitThermal(ctx context.Context, e ThermalEvent) error {
+	return s.writeJSONLine(e)
+}
+
+func (s *fileSink) EmitJobUsage(ctx context.Context, u JobGPUUsage) error {
+	return s.writeJSONLine(u)
+}
+
+func (s *fileSink) writeJSONLine(v interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	day := time.Now().Format("2006-01-02")
+	if s.f == nil || day != s.day {
+		if s.f != nil {
+			if err := s.f.Close(); err != nil {
+				log.Printf("fileSink: error closing rotated file: %v", err)
+			}
+		}
+		path := fmt.Sprintf("%s.%s", s.basePath, day)
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("opening snapshot file %s: %w", path, err)
+		}
+		s.f = f
+		s.day = day
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshaling %T: %w", v, err)
+	}
+	b = append(b, '\n')
+	_, err = s.f.Write(b)
+	return err
+}
+
+// influxSink batches GPUSnapshots and flushes them as InfluxDB v2
+// line-protocol writes over HTTP, retrying transient network errors.
+type influxSink struct {
+	url    string
+	token  string
+	bucket string
+	org    string
+	tags   map[string]string
+
+	client *http.Client
+
+	mu      sync.Mutex
+	pending []GPUSnapshot
+}
+
+func newInfluxSink(ctx context.Context) *influxSink {
+	tags := map[string]string{}
+	for k, v := range viper.GetStringMapString("sink.tags") {
+		tags[k] = v
+	}
+
+	s := &influxSink{
+		url:    strings.TrimRight(viper.GetString("sink.url"), "/"),
+		token:  viper.GetString("sink.token"),
+		bucket: viper.GetString("sink.bucket"),
+		org:    viper.GetString("sink.org"),
+		tags:   tags,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	flushInterval := viper.GetDuration("sink.flush_interval")
+	if flushInterval <= 0 {
+		flushInterval = 30 * time.Second
+	}
+	go s.flushLoop(ctx, flushInterval)
+
+	return s
+}
+
+func (s *influxSink) Emit(ctx context.Context, g GPUSnapshot) error {
+	s.mu.Lock()
+	s.pending = append(s.pending, g)
+	s.mu.Unlock()
+	return nil
+}
+
+// EmitThermal writes immediately rather than batching: thermal events are
+// rare state transitions, not a high-volume stream, and operators want to
+// see them show up without waiting for the next flush.
+func (s *influxSink) EmitThermal(ctx context.Context, e ThermalEvent) error {
+	line := encodeThermalEventLineProtocol(e, s.tags) + "\n"
+	return s.write([]byte(line))
+}
+
+// EmitJobUsage writes immediately rather than batching, same rationale
+// as EmitThermal: per-process accounting samples are infrequent relative
+// to the raw snapshot stream, so there's no throughput reason to delay
+// them to the next flush.
+func (s *influxSink) EmitJobUsage(ctx context.Context, u JobGPUUsage) error {
+	line := encodeJobUsageLineProtocol(u, s.tags) + "\n"
+	return s.write([]byte(line))
+}
+
+// flushLoop flushes on every tick and, on ctx cancellation, flushes once
+// more before returning so a pending batch (up to a full flush_interval
+// of snapshots) isn't silently dropped on shutdown.
+func (s *influxSink) flushLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.flush(); err != nil {
+				log.Printf("influxSink: final flush error: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				log.Printf("influxSink: flush error: %v", err)
+			}
+		}
+	}
+}
+
+func (s *influxSink) flush() error {
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, g := range batch {
+		buf.WriteString(encodeLineProtocol(g, s.tags))
+		buf.WriteByte('\n')
+	}
+
+	const maxAttempts = 3
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = s.write(buf.Bytes()); err == nil {
+			return nil
+		}
+		log.Printf("influxSink: write attempt %d/%d failed: %v", attempt, maxAttempts, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	return fmt.Errorf("influxSink: giving up after %d attempts: %w", maxAttempts, err)
+}
+
+func (s *influxSink) write(body []byte) error {
+	url := fmt.Sprintf("%s/api/v2/write?org=%s&bucket=%s&precision=ns", s.url, s.org, s.bucket)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+s.token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer check.Err(resp.Body.Close)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("influxdb write returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// encodeLineProtocol maps a GPUSnapshot to a single InfluxDB line-protocol
+// point: UUID, Name and MinorNumber as tags, everything else as fields.
+func encodeLineProtocol(g GPUSnapshot, extraTags map[string]string) string {
+	tags := fmt.Sprintf("gpu_snapshot,uuid=%s,name=%s,minor_number=%d",
+		escapeTag(g.UUID), escapeTag(g.Name), g.MinorNumber)
+	for k, v := range extraTags {
+		tags += fmt.Sprintf(",%s=%s", escapeTag(k), escapeTag(v))
+	}
+
+	fields := fmt.Sprintf(
+		"brand=%di,persistence_mode=%di,compute_mode=%di,performance_state=%di,"+
+			"avg_gpu_utilization=%d,avg_power_usage=%d,total_memory=%di,used_memory=%di,"+
+			"gr_clock=%d,sm_clock=%d,mem_clock=%d,gr_max_clock=%d,sm_max_clock=%d,mem_max_clock=%d,"+
+			"pcie_tx_throughput=%d,pcie_rx_throughput=%d,pcie_generation=%d,pcie_width=%d,"+
+			"pcie_max_generation=%d,pcie_max_width=%d,temperature=%d,fan_speed=%d",
+		g.Brand, g.PersistenceMode, g.ComputeMode, g.PerformanceState,
+		g.AvgGPUUtilization, g.AvgPowerUsage, g.TotalMemory, g.UsedMemory,
+		g.GrClock, g.SMClock, g.MemClock, g.GrMaxClock, g.SMMaxClock, g.MemMaxClock,
+		g.PcieTxThroughput, g.PcieRxThroughput, g.PcieGeneration, g.PcieWidth,
+		g.PcieMaxGeneration, g.PcieMaxWidth, g.Temperature, g.FanSpeed)
+
+	return fmt.Sprintf("%s %s %d", tags, fields, g.TimeStamp*int64(time.Second))
+}
+
+// encodeThermalEventLineProtocol maps a ThermalEvent to a single InfluxDB
+// line-protocol point: UUID, MinorNumber and State as tags.
+func encodeThermalEventLineProtocol(e ThermalEvent, extraTags map[string]string) string {
+	tags := fmt.Sprintf("thermal_event,uuid=%s,minor_number=%d,state=%s",
+		escapeTag(e.UUID), e.MinorNumber, escapeTag(e.State))
+	for k, v := range extraTags {
+		tags += fmt.Sprintf(",%s=%s", escapeTag(k), escapeTag(v))
+	}
+
+	fields := fmt.Sprintf("temperature=%di,avg_power_usage=%di,action=%q",
+		e.Temperature, e.AvgPowerUsage, e.Action)
+
+	return fmt.Sprintf("%s %s %d", tags, fields, e.TimeStamp*int64(time.Second))
+}
+
+// encodeJobUsageLineProtocol maps a JobGPUUsage to a single InfluxDB
+// line-protocol point: MinorNumber and PID as tags.
+func encodeJobUsageLineProtocol(u JobGPUUsage, extraTags map[string]string) string {
+	tags := fmt.Sprintf("job_gpu_usage,minor_number=%d,pid=%d", u.MinorNumber, u.PID)
+	for k, v := range extraTags {
+		tags += fmt.Sprintf(",%s=%s", escapeTag(k), escapeTag(v))
+	}
+
+	fields := fmt.Sprintf("used_gpu_memory=%di,sm_util=%di,mem_util=%di,energy_joules=%di,time_running_ms=%di",
+		u.UsedGpuMemory, u.SmUtil, u.MemUtil, u.EnergyJoules, u.TimeRunning.Milliseconds())
+
+	return fmt.Sprintf("%s %s %d", tags, fields, u.TimeStamp*int64(time.Second))
+}
+
+func escapeTag(s string) string {
+	s = strings.ReplaceAll(s, " ", "\\ ")
+	s = strings.ReplaceAll(s, ",", "\\,")
+	return strings.ReplaceAll(s, "=", "\\=")
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"github.com/wminshew/gonvml"
+	"log"
+	"os"
+	"time"
+)
+
+// thermalConsecutiveSamples is how many back-to-back over-temperature
+// readings are required before throttling kicks in, so a single noisy
+// sensor reading doesn't trip the control loop.
+const thermalConsecutiveSamples = 3
+
+const (
+	thermalStateNormal    = "normal"
+	thermalStateThrottled = "throttled"
+)
+
+// ThermalEvent records a state transition in the thermal self-throttling
+// control loop for a single device, emitted through the sink whenever a
+// device crosses into or out of throttling.
+type ThermalEvent struct {
+	TimeStamp     int64
+	MinorNumber   uint
+	UUID          string
+	State         string
+	Temperature   uint
+	AvgPowerUsage uint
+	Action        string
+}
+
+// thermalController watches GPUSnapshot.Temperature for every device and
+// reacts once a device has run hot for thermalConsecutiveSamples in a
+// row, per the thermal.* viper config (max_temp_c, resume_temp_c,
+// max_power_w, action). It only resumes normal operation once the device
+// has cooled below resume_temp_c.
+type thermalController struct {
+	maxTempC    uint
+	resumeTempC uint
+	maxPowerW   uint
+	maxClockMHz uint
+	action      string
+
+	overTempRuns map[string]int
+	throttled    map[string]bool
+	priorPowerW  map[string]uint
+}
+
+func newThermalController() *thermalController {
+	action := viper.GetString("thermal.action")
+	if action == "pause_job" {
+		log.Printf("thermal.action is pause_job, but this tree has no job-runner integration yet " +
+			"(pkg/job is still a commented-out import): it will only log, not actually pause anything")
+	}
+
+	return &thermalController{
+		maxTempC:     uint(viper.GetInt("thermal.max_temp_c")),
+		resumeTempC:  uint(viper.GetInt("thermal.resume_temp_c")),
+		maxPowerW:    uint(viper.GetInt("thermal.max_power_w")),
+		maxClockMHz:  uint(viper.GetInt("thermal.max_clock_mhz")),
+		action:       action,
+		overTempRuns: map[string]int{},
+		throttled:    map[string]bool{},
+		priorPowerW:  map[string]uint{},
+	}
+}
+
+// check folds in the latest snapshot and, if it crosses a thermal
+// boundary, applies or clears the configured throttling action and
+// emits a ThermalEvent for the transition.
+func (c *thermalController) check(ctx context.Context, dev gonvml.Device, g GPUSnapshot, sink ThermalSink) {
+	if c.maxTempC == 0 {
+		// thermal throttling isn't configured
+		return
+	}
+
+	if g.Temperature >= c.maxTempC {
+		c.overTempRuns[g.UUID]++
+	} else {
+		c.overTempRuns[g.UUID] = 0
+	}
+
+	switch {
+	case !c.throttled[g.UUID] && c.overTempRuns[g.UUID] >= thermalConsecutiveSamples:
+		c.throttled[g.UUID] = true
+		c.applyThrottle(dev, g.UUID)
+		c.emit(ctx, sink, g, thermalStateThrottled)
+	case c.throttled[g.UUID] && g.Temperature < c.resumeTempC:
+		c.throttled[g.UUID] = false
+		c.overTempRuns[g.UUID] = 0
+		c.clearThrottle(dev, g.UUID)
+		c.emit(ctx, sink, g, thermalStateNormal)
+	}
+}
+
+func (c *thermalController) applyThrottle(dev gonvml.Device, uuid string) {
+	log.Printf("thermal: device over %d C for %d samples, applying %q", c.maxTempC, thermalConsecutiveSamples, c.action)
+
+	switch c.action {
+	case "pause_job":
+		signalJobPause()
+	case "apply_power_limit":
+		if c.maxPowerW == 0 {
+			log.Printf("thermal.action is apply_power_limit but thermal.max_power_w is unset")
+			return
+		}
+		if prior, err := dev.PowerManagementLimit(); err != nil {
+			log.Printf("PowerManagementLimit() error: %v", err)
+		} else {
+			c.priorPowerW[uuid] = prior
+		}
+		if err := dev.SetPowerManagementLimit(c.maxPowerW * 1000); err != nil {
+			log.Printf("SetPowerManagementLimit() error: %v", err)
+		}
+	case "apply_clock_limit":
+		if c.maxClockMHz == 0 {
+			log.Printf("thermal.action is apply_clock_limit but thermal.max_clock_mhz is unset")
+			return
+		}
+		if err := dev.SetGpuLockedClocks(0, c.maxClockMHz); err != nil {
+			log.Printf("SetGpuLockedClocks() error: %v", err)
+		}
+	case "abort":
+		log.Printf("thermal: aborting, device exceeded safe operating temperature")
+		os.Exit(1)
+	default:
+		log.Printf("thermal: unknown thermal.action %q", c.action)
+	}
+}
+
+func (c *thermalController) clearThrottle(dev gonvml.Device, uuid string) {
+	log.Printf("thermal: device back below %d C, clearing %q", c.resumeTempC, c.action)
+
+	switch c.action {
+	case "pause_job":
+		signalJobResume()
+	case "apply_power_limit":
+		// 0 is below any device's minimum power limit and NVML rejects it;
+		// restore whatever the limit was before applyThrottle changed it
+		// (falling back to the default limit if we never captured one, e.g.
+		// PowerManagementLimit() failed in applyThrottle) instead of passing 0.
+		limit, ok := c.priorPowerW[uuid]
+		if !ok {
+			var err error
+			limit, err = dev.PowerManagementDefaultLimit()
+			if err != nil {
+				log.Printf("PowerManagementDefaultLimit() error: %v", err)
+				return
+			}
+		}
+		if err := dev.SetPowerManagementLimit(limit); err != nil {
+			log.Printf("SetPowerManagementLimit() error: %v", err)
+		}
+		delete(c.priorPowerW, uuid)
+	case "apply_clock_limit":
+		if err := dev.ResetGpuLockedClocks(); err != nil {
+			log.Printf("ResetGpuLockedClocks() error: %v", err)
+		}
+	}
+}
+
+func (c *thermalController) emit(ctx context.Context, sink ThermalSink, g GPUSnapshot, state string) {
+	e := ThermalEvent{
+		TimeStamp:     time.Now().Unix(),
+		MinorNumber:   g.MinorNumber,
+		UUID:          g.UUID,
+		State:         state,
+		Temperature:   g.Temperature,
+		AvgPowerUsage: g.AvgPowerUsage,
+		Action:        c.action,
+	}
+	if err := sink.EmitThermal(ctx, e); err != nil {
+		log.Printf("sink.EmitThermal() error: %v", err)
+	}
+}
+
+// signalJobPause and signalJobResume are stubs standing in for the
+// integration point with the job runner (github.com/wminshew/emrys/pkg/job,
+// currently unimported in this package, see newThermalController's
+// startup warning). They don't pause or resume anything yet; wire them
+// to the real job runner once that package is back in the import graph.
+func signalJobPause() {
+	log.Printf("thermal: signaling job runner to pause")
+}
+
+func signalJobResume() {
+	log.Printf("thermal: signaling job runner to resume")
+}